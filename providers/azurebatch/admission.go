@@ -0,0 +1,168 @@
+package azurebatch
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// FailedValidation is the event reason recorded when a pod is rejected by admitPod,
+// mirroring kubelet's FailedValidation event for pods that fail its own admission checks.
+const FailedValidation = "FailedValidation"
+
+// rejectedPodCache holds the terminal Failed status of pods that admitPod rejected before a
+// Batch task was ever created for them. The statusManager only knows about tasks that made
+// it into the job, so without this a rejected pod's Failed status would only ever live on
+// the single in-memory *v1.Pod handed to that one CreatePod call - GetPod/GetPodStatus would
+// report it as simply not found afterwards.
+type rejectedPodCache struct {
+	mu   sync.RWMutex
+	pods map[string]*v1.Pod
+}
+
+func newRejectedPodCache() *rejectedPodCache {
+	return &rejectedPodCache{pods: make(map[string]*v1.Pod)}
+}
+
+func (c *rejectedPodCache) set(taskID string, pod *v1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pods[taskID] = pod.DeepCopy()
+}
+
+func (c *rejectedPodCache) delete(taskID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pods, taskID)
+}
+
+func (c *rejectedPodCache) get(taskID string) (*v1.Pod, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pod, ok := c.pods[taskID]
+	return pod, ok
+}
+
+// admitPod runs a pod through the same kind of validation pipeline kubelet's
+// filterInvalidPods/canAdmitPod perform before a pod is allowed to run: reject duplicates,
+// reject pods that can't fit the pool's capacity, and reject unsupported pod features.
+// Unlike CreatePod's prior behaviour of silently swallowing the Batch Add error, a rejection
+// here is surfaced to the user via a FailedValidation event and a Failed pod status.
+func (p *Provider) admitPod(pod *v1.Pod) error {
+	if err := p.checkDuplicate(pod); err != nil {
+		return err
+	}
+	if err := p.checkCapacity(pod); err != nil {
+		return err
+	}
+	if err := p.checkSupportedFeatures(pod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkDuplicate rejects a pod whose namespace/name is already tracked by the statusManager,
+// i.e. a task with that ID already exists in the job.
+func (p *Provider) checkDuplicate(pod *v1.Pod) error {
+	if _, _, ok := p.statusManager.get(getTaskIDForPod(pod.Namespace, pod.Name)); ok {
+		return fmt.Errorf("pod %s/%s is already tracked by this node", pod.Namespace, pod.Name)
+	}
+	return nil
+}
+
+// checkCapacity rejects a pod whose aggregated container resource requests exceed either
+// the provider's advertised Capacity() or a single node's capacity for the configured
+// VMSize - a pod that can't fit on any node would otherwise sit pending forever.
+func (p *Provider) checkCapacity(pod *v1.Pod) error {
+	requested := v1.ResourceList{
+		"cpu":    resource.MustParse("0"),
+		"memory": resource.MustParse("0"),
+	}
+	for _, container := range pod.Spec.Containers {
+		if cpu, ok := container.Resources.Requests["cpu"]; ok {
+			sum := requested["cpu"]
+			sum.Add(cpu)
+			requested["cpu"] = sum
+		}
+		if mem, ok := container.Resources.Requests["memory"]; ok {
+			sum := requested["memory"]
+			sum.Add(mem)
+			requested["memory"] = sum
+		}
+	}
+
+	capacity := p.Capacity()
+	if requested["cpu"].Cmp(capacity["cpu"]) > 0 {
+		return fmt.Errorf("pod %s/%s requests %s cpu which exceeds node capacity %s", pod.Namespace, pod.Name, requested["cpu"].String(), capacity["cpu"].String())
+	}
+	if requested["memory"].Cmp(capacity["memory"]) > 0 {
+		return fmt.Errorf("pod %s/%s requests %s memory which exceeds node capacity %s", pod.Namespace, pod.Name, requested["memory"].String(), capacity["memory"].String())
+	}
+
+	if perNode, ok := vmSizeCapacity[p.batchConfig.VMSize]; ok {
+		if requested["cpu"].Cmp(perNode["cpu"]) > 0 {
+			return fmt.Errorf("pod %s/%s requests %s cpu which exceeds a single %s node", pod.Namespace, pod.Name, requested["cpu"].String(), p.batchConfig.VMSize)
+		}
+		if requested["memory"].Cmp(perNode["memory"]) > 0 {
+			return fmt.Errorf("pod %s/%s requests %s memory which exceeds a single %s node", pod.Namespace, pod.Name, requested["memory"].String(), p.batchConfig.VMSize)
+		}
+	}
+
+	return nil
+}
+
+// checkSupportedFeatures rejects pods that ask for features this provider doesn't back:
+// hostNetwork, hostPID, privileged containers (unless the pool opted in via
+// Config.AllowPrivileged), volume types resolveVolumes doesn't handle, and AzureFile or
+// PersistentVolumeClaim volumes when no storage account/file share is configured.
+func (p *Provider) checkSupportedFeatures(pod *v1.Pod) error {
+	if pod.Spec.HostNetwork {
+		return fmt.Errorf("pod %s/%s requests hostNetwork, which is not supported by the azurebatch provider", pod.Namespace, pod.Name)
+	}
+	if pod.Spec.HostPID {
+		return fmt.Errorf("pod %s/%s requests hostPID, which is not supported by the azurebatch provider", pod.Namespace, pod.Name)
+	}
+	if !p.batchConfig.AllowPrivileged {
+		for _, container := range pod.Spec.Containers {
+			if container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+				return fmt.Errorf("pod %s/%s container %q requests privileged, which this pool isn't configured to allow (set Config.AllowPrivileged)", pod.Namespace, pod.Name, container.Name)
+			}
+		}
+	}
+	for _, vol := range pod.Spec.Volumes {
+		switch {
+		case vol.ConfigMap != nil, vol.Secret != nil, vol.EmptyDir != nil:
+			continue
+		case vol.AzureFile != nil, vol.PersistentVolumeClaim != nil:
+			if p.batchConfig.StorageAccountName == "" || p.batchConfig.FileShareName == "" {
+				return fmt.Errorf("pod %s/%s volume %q needs the Azure Files share, but no storage account/file share is configured", pod.Namespace, pod.Name, vol.Name)
+			}
+			continue
+		default:
+			return fmt.Errorf("pod %s/%s volume %q has an unsupported type", pod.Namespace, pod.Name, vol.Name)
+		}
+	}
+	return nil
+}
+
+// failPod records a FailedValidation event against the pod (when a recorder is configured),
+// marks it Failed, and stashes that status in rejectedPods so a later GetPod/GetPodStatus -
+// which otherwise only knows about tasks that reached Batch - can still return it. That's
+// what makes the rejection visible via `kubectl describe pod` rather than just the Event.
+// pod is deep-copied before its status is mutated: it typically originates from a shared
+// informer/lister cache, and callers elsewhere in the codebase never expect CreatePod to
+// modify the object handed to them in place.
+func (p *Provider) failPod(pod *v1.Pod, reason error) {
+	if p.recorder != nil {
+		p.recorder.Event(pod, v1.EventTypeWarning, FailedValidation, reason.Error())
+	}
+
+	failed := pod.DeepCopy()
+	failed.Status.Phase = v1.PodFailed
+	failed.Status.Reason = FailedValidation
+	failed.Status.Message = reason.Error()
+
+	p.rejectedPods.set(getTaskIDForPod(pod.Namespace, pod.Name), failed)
+}
@@ -0,0 +1,91 @@
+package azurebatch
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func podRequesting(cpu, memory string) *v1.Pod {
+	return &v1.Pod{
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							"cpu":    resource.MustParse(cpu),
+							"memory": resource.MustParse(memory),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	p := &Provider{
+		cpu:    "4",
+		memory: "14Gi",
+		pods:   "20",
+		batchConfig: &Config{
+			VMSize:   "STANDARD_D2_V2",
+			MaxNodes: 2,
+		},
+	}
+
+	t.Run("fits within a single node", func(t *testing.T) {
+		if err := p.checkCapacity(podRequesting("1", "1Gi")); err != nil {
+			t.Errorf("checkCapacity() = %v, want nil", err)
+		}
+	})
+
+	t.Run("exceeds a single node's cpu", func(t *testing.T) {
+		if err := p.checkCapacity(podRequesting("3", "1Gi")); err == nil {
+			t.Errorf("checkCapacity() = nil, want an error for a pod that can't fit on one node")
+		}
+	})
+
+	t.Run("exceeds a single node's memory", func(t *testing.T) {
+		if err := p.checkCapacity(podRequesting("1", "10Gi")); err == nil {
+			t.Errorf("checkCapacity() = nil, want an error for a pod that can't fit on one node")
+		}
+	})
+}
+
+func TestCheckSupportedFeatures(t *testing.T) {
+	privileged := true
+	privilegedPod := &v1.Pod{
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:            "c",
+					SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+				},
+			},
+		},
+	}
+
+	t.Run("rejected when AllowPrivileged is false", func(t *testing.T) {
+		p := &Provider{batchConfig: &Config{}}
+		if err := p.checkSupportedFeatures(privilegedPod); err == nil {
+			t.Error("checkSupportedFeatures() = nil, want an error for a privileged container")
+		}
+	})
+
+	t.Run("admitted when AllowPrivileged is true", func(t *testing.T) {
+		p := &Provider{batchConfig: &Config{AllowPrivileged: true}}
+		if err := p.checkSupportedFeatures(privilegedPod); err != nil {
+			t.Errorf("checkSupportedFeatures() = %v, want nil when AllowPrivileged is set", err)
+		}
+	})
+
+	t.Run("hostNetwork always rejected", func(t *testing.T) {
+		p := &Provider{batchConfig: &Config{AllowPrivileged: true}}
+		pod := &v1.Pod{Spec: v1.PodSpec{HostNetwork: true}}
+		if err := p.checkSupportedFeatures(pod); err == nil {
+			t.Error("checkSupportedFeatures() = nil, want an error for hostNetwork")
+		}
+	})
+}
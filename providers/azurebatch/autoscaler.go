@@ -0,0 +1,199 @@
+package azurebatch
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/batch/2017-09-01.6.0/batch"
+	"github.com/Azure/go-autorest/autorest/to"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// autoscaleInterval is how often the autoscaler reevaluates pending demand against the
+// pool's current size.
+const autoscaleInterval = 30 * time.Second
+
+// vmSizeCapacity is the per-node CPU/memory capacity used to translate admitted pods'
+// resource requests into a node count. A small built-in table covers the common D-series
+// sizes; operators running other VMSizes should extend this alongside Config.VMSize.
+var vmSizeCapacity = map[string]v1.ResourceList{
+	"STANDARD_D1_V2": {"cpu": resource.MustParse("1"), "memory": resource.MustParse("3.5Gi")},
+	"STANDARD_D2_V2": {"cpu": resource.MustParse("2"), "memory": resource.MustParse("7Gi")},
+	"STANDARD_D3_V2": {"cpu": resource.MustParse("4"), "memory": resource.MustParse("14Gi")},
+	"STANDARD_D4_V2": {"cpu": resource.MustParse("8"), "memory": resource.MustParse("28Gi")},
+}
+
+// autoscaler resizes the Batch pool to track the CPU/memory requests of admitted pods,
+// mirroring a cluster-autoscaler: it grows the pool when there's pending work it can't
+// currently fit and shrinks it once nodes have sat idle past Config.IdleNodeTTL.
+type autoscaler struct {
+	mu sync.Mutex
+
+	provider *Provider
+
+	currentNodes int32
+	idleSince    *time.Time
+}
+
+// newAutoscaler creates an autoscaler for the given provider, starting from the pool's
+// configured MinNodes.
+func newAutoscaler(p *Provider) *autoscaler {
+	return &autoscaler{
+		provider:     p,
+		currentNodes: p.batchConfig.MinNodes,
+	}
+}
+
+// Run starts the autoscaling loop and blocks until ctx is cancelled.
+func (a *autoscaler) Run(ctx context.Context) {
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.reconcile()
+		}
+	}
+}
+
+// reconcile sums the resource requests of pods admitted but not yet complete, compares that
+// against the pool's current node capacity, and resizes the pool to fit - growing
+// immediately when demand exceeds capacity, shrinking only after IdleNodeTTL of slack.
+func (a *autoscaler) reconcile() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pending, err := a.pendingRequests()
+	if err != nil {
+		log.Printf("azurebatch: autoscaler failed to list pending demand: %v", err)
+		return
+	}
+
+	perNode, ok := vmSizeCapacity[a.provider.batchConfig.VMSize]
+	if !ok {
+		log.Printf("azurebatch: autoscaler has no known capacity for VMSize %q, skipping resize", a.provider.batchConfig.VMSize)
+		return
+	}
+
+	desired := nodesNeeded(pending, perNode)
+	if desired < a.provider.batchConfig.MinNodes {
+		desired = a.provider.batchConfig.MinNodes
+	}
+	if desired > a.provider.batchConfig.MaxNodes {
+		desired = a.provider.batchConfig.MaxNodes
+	}
+
+	now := time.Now()
+	if desired >= a.currentNodes {
+		a.idleSince = nil
+	} else {
+		if a.idleSince == nil {
+			a.idleSince = &now
+		}
+		if now.Sub(*a.idleSince) < a.provider.batchConfig.IdleNodeTTL {
+			// Not idle long enough yet to shrink - hold at the current size.
+			desired = a.currentNodes
+		}
+	}
+
+	if desired == a.currentNodes {
+		return
+	}
+
+	if err := resizePool(a.provider, desired); err != nil {
+		log.Printf("azurebatch: failed to resize pool to %d nodes: %v", desired, err)
+		return
+	}
+
+	log.Printf("azurebatch: resized pool from %d to %d nodes", a.currentNodes, desired)
+	a.currentNodes = desired
+	a.idleSince = nil
+}
+
+// pendingRequests sums the CPU/memory requests across every pod the resourceManager holds
+// that isn't in the Succeeded or Failed phase - i.e. demand the pool needs capacity for right now.
+func (a *autoscaler) pendingRequests() (v1.ResourceList, error) {
+	total := v1.ResourceList{
+		"cpu":    resource.MustParse("0"),
+		"memory": resource.MustParse("0"),
+	}
+
+	for _, pod := range a.provider.resourceManager.GetPods() {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			if cpu, ok := container.Resources.Requests["cpu"]; ok {
+				sum := total["cpu"]
+				sum.Add(cpu)
+				total["cpu"] = sum
+			}
+			if mem, ok := container.Resources.Requests["memory"]; ok {
+				sum := total["memory"]
+				sum.Add(mem)
+				total["memory"] = sum
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// nodesNeeded converts aggregated pending resource requests into a node count, rounding up
+// so partially-filled nodes still count as a full node of capacity.
+func nodesNeeded(pending v1.ResourceList, perNode v1.ResourceList) int32 {
+	cpuNodes := ceilDiv(pending["cpu"].MilliValue(), perNode["cpu"].MilliValue())
+	memNodes := ceilDiv(pending["memory"].Value(), perNode["memory"].Value())
+
+	if cpuNodes > memNodes {
+		return int32(cpuNodes)
+	}
+	return int32(memNodes)
+}
+
+// atMaxWithPendingWork reports whether the pool is already at MaxNodes while there's still
+// pending demand it can't fit - the signal NodeConditions surfaces as MemoryPressure.
+func (a *autoscaler) atMaxWithPendingWork() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.currentNodes < a.provider.batchConfig.MaxNodes {
+		return false
+	}
+
+	pending, err := a.pendingRequests()
+	if err != nil {
+		return false
+	}
+
+	perNode, ok := vmSizeCapacity[a.provider.batchConfig.VMSize]
+	if !ok {
+		return false
+	}
+
+	return nodesNeeded(pending, perNode) > a.currentNodes
+}
+
+// resizePool calls the Batch PoolClient to grow or shrink the pool to the target node count.
+func resizePool(p *Provider, targetNodes int32) error {
+	_, err := p.poolClient.Resize(p.ctx, p.batchConfig.PoolID, batch.PoolResizeParameter{
+		TargetDedicatedNodes: to.Int32Ptr(targetNodes),
+	}, nil, nil, nil, nil, "", "", nil, nil)
+	return err
+}
+
+func ceilDiv(a, b int64) int64 {
+	if b == 0 {
+		return 0
+	}
+	if a%b == 0 {
+		return a / b
+	}
+	return a/b + 1
+}
@@ -0,0 +1,83 @@
+package azurebatch
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestCeilDiv(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b int64
+		want int64
+	}{
+		{"evenly divisible", 4000, 2000, 2},
+		{"rounds up", 4001, 2000, 3},
+		{"zero numerator", 0, 2000, 0},
+		{"zero denominator", 4000, 0, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ceilDiv(tc.a, tc.b); got != tc.want {
+				t.Errorf("ceilDiv(%d, %d) = %d, want %d", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNodesNeeded(t *testing.T) {
+	perNode := v1.ResourceList{
+		"cpu":    resource.MustParse("2"),
+		"memory": resource.MustParse("7Gi"),
+	}
+
+	cases := []struct {
+		name    string
+		pending v1.ResourceList
+		want    int32
+	}{
+		{
+			name: "fits on one node",
+			pending: v1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("1Gi"),
+			},
+			want: 1,
+		},
+		{
+			name: "cpu bound",
+			pending: v1.ResourceList{
+				"cpu":    resource.MustParse("5"),
+				"memory": resource.MustParse("1Gi"),
+			},
+			want: 3,
+		},
+		{
+			name: "memory bound",
+			pending: v1.ResourceList{
+				"cpu":    resource.MustParse("1"),
+				"memory": resource.MustParse("20Gi"),
+			},
+			want: 3,
+		},
+		{
+			name: "no pending demand",
+			pending: v1.ResourceList{
+				"cpu":    resource.MustParse("0"),
+				"memory": resource.MustParse("0"),
+			},
+			want: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nodesNeeded(tc.pending, perNode); got != tc.want {
+				t.Errorf("nodesNeeded() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
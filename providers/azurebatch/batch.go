@@ -5,9 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Azure/go-autorest/autorest/azure"
-	"io/ioutil"
 	"log"
-	"net/http"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest/to"
 
@@ -17,6 +16,7 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -45,6 +45,10 @@ type Provider struct {
 	pods               string
 	internalIP         string
 	daemonEndpointPort int32
+	statusManager      *statusManager
+	autoscaler         *autoscaler
+	recorder           record.EventRecorder
+	rejectedPods       *rejectedPodCache
 }
 
 // Config - Basic azure config used to interact with ARM resources.
@@ -58,10 +62,29 @@ type Config struct {
 	JobID           string
 	AccountName     string
 	AccountLocation string
+
+	// StorageAccountName, StorageAccountKey and FileShareName configure the Azure Files
+	// share that the pool's nodes mount to back PersistentVolumeClaim and AzureFile pod
+	// volumes. Left blank, pods using those volume types will fail admission.
+	StorageAccountName string
+	StorageAccountKey  string
+	FileShareName      string
+
+	// VMSize, MaxNodes, MinNodes and IdleNodeTTL configure the autoscaler: the VM size
+	// used to translate pending pod resource requests into a node count, the bounds it
+	// may resize the pool within, and how long a node must sit idle before scaling down.
+	VMSize      string
+	MaxNodes    int32
+	MinNodes    int32
+	IdleNodeTTL time.Duration
+
+	// AllowPrivileged opts the pool into running privileged containers. Left false (the
+	// default), admitPod rejects any pod that asks for one.
+	AllowPrivileged bool
 }
 
 // NewBatchProvider Creates a batch provider
-func NewBatchProvider(config string, rm *manager.ResourceManager, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32) (*Provider, error) {
+func NewBatchProvider(config string, rm *manager.ResourceManager, recorder record.EventRecorder, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32) (*Provider, error) {
 	fmt.Println("Starting create provider")
 
 	batchConfig, err := getAzureConfigFromEnv()
@@ -76,6 +99,8 @@ func NewBatchProvider(config string, rm *manager.ResourceManager, nodeName, oper
 	p.memory = "100Gi"
 	p.pods = "20"
 	p.resourceManager = rm
+	p.recorder = recorder
+	p.rejectedPods = newRejectedPodCache()
 	p.operatingSystem = operatingSystem
 	p.nodeName = nodeName
 	p.internalIP = internalIP
@@ -85,6 +110,9 @@ func NewBatchProvider(config string, rm *manager.ResourceManager, nodeName, oper
 	auth := getAzureADAuthorizer(p.batchConfig, azure.PublicCloud.BatchManagementEndpoint)
 
 	createOrGetPool(&p, auth)
+	if err := p.ensureFilesShareMounted(); err != nil {
+		log.Println(err)
+	}
 	createOrGetJob(&p, auth)
 
 	taskclient := batch.NewTaskClientWithBaseURI(getBatchBaseURL(p.batchConfig))
@@ -115,12 +143,24 @@ func NewBatchProvider(config string, rm *manager.ResourceManager, nodeName, oper
 	fileClient.Authorizer = auth
 	p.fileClient = &fileClient
 
+	p.statusManager = newStatusManager(p.listTasks)
+	go p.statusManager.Run(p.ctx)
+
+	p.autoscaler = newAutoscaler(&p)
+	go p.autoscaler.Run(p.ctx)
+
 	return &p, nil
 }
 
 // CreatePod accepts a Pod definition
 func (p *Provider) CreatePod(pod *v1.Pod) error {
 	log.Println("Creating pod...")
+
+	if err := p.admitPod(pod); err != nil {
+		p.failPod(pod, err)
+		return err
+	}
+
 	podCommand, err := pod2docker.GetBashCommand(pod2docker.PodComponents{
 		Containers: pod.Spec.Containers,
 		PodName:    pod.Name,
@@ -135,6 +175,11 @@ func (p *Provider) CreatePod(pod *v1.Pod) error {
 		panic(err)
 	}
 
+	resourceFiles, err := p.resolveVolumes(pod)
+	if err != nil {
+		return err
+	}
+
 	task := batch.TaskAddParameter{
 		DisplayName: to.StringPtr(string(pod.UID)),
 		ID:          to.StringPtr(getTaskIDForPod(pod.Namespace, pod.Name)),
@@ -145,6 +190,7 @@ func (p *Provider) CreatePod(pod *v1.Pod) error {
 				Scope:          batch.Pool,
 			},
 		},
+		ResourceFiles: &resourceFiles,
 		EnvironmentSettings: &[]batch.EnvironmentSetting{
 			{
 				Name:  to.StringPtr(podJsonKey),
@@ -152,12 +198,17 @@ func (p *Provider) CreatePod(pod *v1.Pod) error {
 			},
 		},
 	}
-	p.taskClient.Add(p.ctx, p.batchConfig.JobID, task, nil, nil, nil, nil)
+	if _, err := p.taskClient.Add(p.ctx, p.batchConfig.JobID, task, nil, nil, nil, nil); err != nil {
+		p.failPod(pod, err)
+		return err
+	}
+	p.rejectedPods.delete(getTaskIDForPod(pod.Namespace, pod.Name))
+	p.statusManager.requestSync()
 
 	return nil
 }
 
-// GetPodStatus retrieves the status of a given pod by name.
+// GetPodStatus retrieves the status of a given pod by name from the statusManager's cache.
 func (p *Provider) GetPodStatus(namespace, name string) (*v1.PodStatus, error) {
 	log.Println("Getting pod status ....")
 	pod, err := p.GetPod(namespace, name)
@@ -187,6 +238,14 @@ func (p *Provider) UpdatePod(pod *v1.Pod) error {
 // DeletePod accepts a Pod definition
 func (p *Provider) DeletePod(pod *v1.Pod) error {
 	taskID := getTaskIDForPod(pod.Namespace, pod.Name)
+
+	if _, rejected := p.rejectedPods.get(taskID); rejected {
+		// Never made it to Batch - there's nothing to delete there, just drop the
+		// cached Failed status.
+		p.rejectedPods.delete(taskID)
+		return nil
+	}
+
 	task, err := p.taskClient.Delete(p.ctx, p.batchConfig.JobID, taskID, nil, nil, nil, nil, "", "", nil, nil)
 	if err != nil {
 		log.Println(task)
@@ -194,20 +253,29 @@ func (p *Provider) DeletePod(pod *v1.Pod) error {
 		return err
 	}
 
+	if err := p.cleanupVolumes(pod); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	p.statusManager.forget(taskID)
+	p.statusManager.requestSync()
 	log.Printf(fmt.Sprintf("Deleting task: %v", taskID))
 	return nil
 }
 
-// GetPod returns a pod by name
+// GetPod returns a pod by name, reading its status from the statusManager's cache instead
+// of calling Batch directly.
 func (p *Provider) GetPod(namespace, name string) (*v1.Pod, error) {
 	log.Println("Getting Pod ...")
-	task, err := p.taskClient.Get(p.ctx, p.batchConfig.JobID, getTaskIDForPod(namespace, name), "", "", nil, nil, nil, nil, "", "", nil, nil)
-	if err != nil {
-		if task.Response.StatusCode == http.StatusNotFound {
-			return nil, nil
+	taskID := getTaskIDForPod(namespace, name)
+
+	task, startTime, ok := p.statusManager.get(taskID)
+	if !ok {
+		if rejected, ok := p.rejectedPods.get(taskID); ok {
+			return rejected, nil
 		}
-		log.Println(err)
-		return nil, err
+		return nil, nil
 	}
 
 	pod, err := getPodFromTask(&task)
@@ -215,81 +283,87 @@ func (p *Provider) GetPod(namespace, name string) (*v1.Pod, error) {
 		panic(err)
 	}
 
-	// jsonBytpes, _ := json.Marshal(task)
-	// if pod.Labels == nil {
-	// 	pod.Labels = make(map[string]string)
-	// }
-	// pod.Labels["batchStatus"] = string(jsonBytpes)
 	status, _ := convertTaskToPodStatus(&task)
+	if startTime != nil {
+		status.StartTime = startTime
+	}
 	pod.Status = *status
 
 	return pod, nil
 }
 
-// GetContainerLogs returns the logs of a container running in a pod by name.
-func (p *Provider) GetContainerLogs(namespace, podName, containerName string, tail int) (string, error) {
-	log.Println("Getting pod logs ....")
-
-	logFileLocation := fmt.Sprintf("wd/%s", containerName)
-	// todo: Log file is the json log from docker - deserialise and form at it before returning it.
-	reader, err := p.fileClient.GetFromTask(p.ctx, p.batchConfig.JobID, getTaskIDForPod(namespace, podName), logFileLocation, nil, nil, nil, nil, "", nil, nil)
-
-	if err != nil {
-		return "", err
-	}
-
-	bytes, err := ioutil.ReadAll(*reader.Value)
-
-	if err != nil {
-		return "", err
-	}
-
-	return string(bytes), nil
-}
-
-// GetPods retrieves a list of all pods scheduled to run.
+// GetPods retrieves a list of all pods scheduled to run. Once the statusManager's cache has
+// warmed up this is O(1) against Batch - it reads the cache rather than paging through
+// listTasks on every call.
 func (p *Provider) GetPods() ([]*v1.Pod, error) {
 	log.Println("Getting pods...")
-	tasksPtr, err := p.listTasks()
-	if err != nil {
-		panic(err)
-	}
-	if tasksPtr == nil {
-		return []*v1.Pod{}, nil
-	}
-
-	tasks := *tasksPtr
+	entries := p.statusManager.list()
 
-	pods := make([]*v1.Pod, len(tasks), len(tasks))
-	for i, t := range tasks {
-		pod, err := getPodFromTask(&t)
+	pods := make([]*v1.Pod, len(entries), len(entries))
+	for i, entry := range entries {
+		pod, err := getPodFromTask(&entry.task)
 		if err != nil {
 			panic(err)
 		}
+		status, _ := convertTaskToPodStatus(&entry.task)
+		if entry.startTime != nil {
+			status.StartTime = entry.startTime
+		}
+		pod.Status = *status
 		pods[i] = pod
 	}
 
-	// for _, pod := range pods {
-	// 	// status, _ := p.GetPodStatus(pod.Namespace, pod.Name)
-	// 	if status != nil {
-	// 		pod.Status = *status
-	// 	}
-	// }
 	return pods, nil
 }
 
-// Capacity returns a resource list containing the capacity limits
+// Capacity returns a resource list containing the capacity limits. When the autoscaler is
+// configured this reports the pool's current maximum size rather than the hard-coded
+// defaults, since MaxNodes defines how far the pool can actually burst to.
 func (p *Provider) Capacity() v1.ResourceList {
+	if p.autoscaler == nil || p.batchConfig.MaxNodes == 0 {
+		return v1.ResourceList{
+			"cpu":    resource.MustParse(p.cpu),
+			"memory": resource.MustParse(p.memory),
+			"pods":   resource.MustParse(p.pods),
+		}
+	}
+
+	perNode, ok := vmSizeCapacity[p.batchConfig.VMSize]
+	if !ok {
+		return v1.ResourceList{
+			"cpu":    resource.MustParse(p.cpu),
+			"memory": resource.MustParse(p.memory),
+			"pods":   resource.MustParse(p.pods),
+		}
+	}
+
+	cpu := perNode["cpu"].DeepCopy()
+	cpu.Set(cpu.Value() * int64(p.batchConfig.MaxNodes))
+	memory := perNode["memory"].DeepCopy()
+	memory.Set(memory.Value() * int64(p.batchConfig.MaxNodes))
+
+	// One pod runs per node in this provider, so the pool can host at most MaxNodes pods -
+	// advertising the hard-coded default here would silently cap scheduling at 20 no matter
+	// how far the pool actually scales.
 	return v1.ResourceList{
-		"cpu":    resource.MustParse(p.cpu),
-		"memory": resource.MustParse(p.memory),
-		"pods":   resource.MustParse(p.pods),
+		"cpu":    cpu,
+		"memory": memory,
+		"pods":   *resource.NewQuantity(int64(p.batchConfig.MaxNodes), resource.DecimalSI),
 	}
 }
 
 // NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), for updates to the node status
 // within Kubernetes.
 func (p *Provider) NodeConditions() []v1.NodeCondition {
+	memoryPressure := v1.ConditionFalse
+	memoryPressureReason := "KubeletHasSufficientMemory"
+	memoryPressureMessage := "kubelet has sufficient memory available"
+	if p.autoscaler != nil && p.autoscaler.atMaxWithPendingWork() {
+		memoryPressure = v1.ConditionTrue
+		memoryPressureReason = "PoolAtMaxNodes"
+		memoryPressureMessage = "pool is at MaxNodes and tasks are still pending"
+	}
+
 	return []v1.NodeCondition{
 		{
 			Type:               "Ready",
@@ -309,11 +383,11 @@ func (p *Provider) NodeConditions() []v1.NodeCondition {
 		},
 		{
 			Type:               "MemoryPressure",
-			Status:             v1.ConditionFalse,
+			Status:             memoryPressure,
 			LastHeartbeatTime:  metav1.Now(),
 			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasSufficientMemory",
-			Message:            "kubelet has sufficient memory available",
+			Reason:             memoryPressureReason,
+			Message:            memoryPressureMessage,
 		},
 		{
 			Type:               "DiskPressure",
@@ -359,4 +433,4 @@ func (p *Provider) NodeDaemonEndpoints() *v1.NodeDaemonEndpoints {
 // OperatingSystem returns the operating system for this provider.
 func (p *Provider) OperatingSystem() string {
 	return p.operatingSystem
-}
\ No newline at end of file
+}
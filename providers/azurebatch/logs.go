@@ -0,0 +1,253 @@
+package azurebatch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// LogOptions controls how GetContainerLogsStream retrieves and formats a container's logs,
+// mirroring the options the virtual-kubelet root exposes to `kubectl logs`.
+type LogOptions struct {
+	// Tail limits output to the last N lines. Zero means no limit.
+	Tail int
+	// Follow keeps the stream open, polling for new content until ctx is cancelled.
+	Follow bool
+	// Timestamps prefixes each line with the time it was logged.
+	Timestamps bool
+	// SinceSeconds, if non-zero, drops lines logged more than this many seconds ago.
+	SinceSeconds int64
+	// SinceTime, if non-zero, drops lines logged before this time. Takes precedence over
+	// SinceSeconds when both are set.
+	SinceTime time.Time
+}
+
+// dockerLogLine is a single line of docker's JSON log format, as written to stdout.txt and
+// stderr.txt by pod2docker's generated command.
+type dockerLogLine struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// logPollInterval is how often GetContainerLogsStream polls the Batch file API for new
+// content while Follow is set.
+const logPollInterval = 2 * time.Second
+
+// tailSeekBytesPerLine is a rough average line length used to size the initial seek window
+// when honouring Tail, so the common case of tailing a handful of lines out of a large log
+// fetches a small range near EOF instead of downloading the whole file.
+const tailSeekBytesPerLine = 256
+
+// GetContainerLogs returns the logs of a container running in a pod by name. It exists
+// for backwards compatibility with callers that want the whole log as a string; new callers
+// should prefer GetContainerLogsStream, which honours tail/follow/since.
+func (p *Provider) GetContainerLogs(namespace, podName, containerName string, tail int) (string, error) {
+	stream, err := p.GetContainerLogsStream(p.ctx, namespace, podName, containerName, LogOptions{Tail: tail})
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	bytes, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// GetContainerLogsStream returns a reader over a container's logs, parsing docker's
+// JSON-log format and honouring opts.Tail/Follow/SinceTime/SinceSeconds. When opts.Follow is
+// set the returned ReadCloser streams newly appended log content until ctx is cancelled or
+// Close is called.
+func (p *Provider) GetContainerLogsStream(ctx context.Context, namespace, podName, containerName string, opts LogOptions) (io.ReadCloser, error) {
+	logFileLocation := fmt.Sprintf("wd/%s", containerName)
+	taskID := getTaskIDForPod(namespace, podName)
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer pw.Close()
+
+		var offset int64
+		if opts.Tail > 0 {
+			offset = p.resolveTailOffset(ctx, taskID, logFileLocation, opts.Tail)
+		}
+		since := sinceTime(opts)
+		first := true
+
+		for {
+			chunk, newOffset, modified, err := p.readLogRange(ctx, taskID, logFileLocation, offset)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			if modified {
+				lines := parseDockerLogLines(chunk)
+				lines = filterSince(lines, since)
+				if first && opts.Tail > 0 {
+					lines = tailLines(lines, opts.Tail)
+				}
+				for _, line := range lines {
+					if _, err := pw.Write(formatLogLine(line, opts.Timestamps)); err != nil {
+						return
+					}
+				}
+				offset = newOffset
+			}
+			first = false
+
+			if !opts.Follow {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(logPollInterval):
+			}
+		}
+	}()
+
+	return pr, nil
+}
+
+// readLogRange fetches the log file content from offset to EOF using the Batch file API's
+// ocp-range header, so repeated calls while following only pull newly appended bytes. It
+// reports modified=false when the service answers 416 because offset is already at EOF; no
+// If-Modified-Since header is sent.
+func (p *Provider) readLogRange(ctx context.Context, taskID, filePath string, offset int64) ([]byte, int64, bool, error) {
+	ocpRange := fmt.Sprintf("bytes=%d-", offset)
+	reader, err := p.fileClient.GetFromTask(ctx, p.batchConfig.JobID, taskID, filePath, nil, nil, nil, nil, ocpRange, nil, nil)
+	if err != nil {
+		// A 416 (Range Not Satisfiable) means there's nothing new past offset.
+		if isRangeNotSatisfiable(err) {
+			return nil, offset, false, nil
+		}
+		return nil, offset, false, err
+	}
+
+	bytes, err := ioutil.ReadAll(*reader.Value)
+	if err != nil {
+		return nil, offset, false, err
+	}
+
+	return bytes, offset + int64(len(bytes)), true, nil
+}
+
+// resolveTailOffset picks the byte offset readLogRange's first call should seek to so it
+// only pulls roughly the last n lines of filePath, rather than downloading the whole file
+// and discarding everything but the tail in memory. It starts from a heuristic window sized
+// off tailSeekBytesPerLine and doubles it (up to the whole file) if that didn't land on
+// enough parseable lines - lines vary a lot in length, so one guess won't always be enough.
+func (p *Provider) resolveTailOffset(ctx context.Context, taskID, filePath string, tail int) int64 {
+	size, err := p.fileSize(ctx, taskID, filePath)
+	if err != nil || size <= 0 {
+		return 0
+	}
+
+	for window := int64(tail) * tailSeekBytesPerLine; ; window *= 2 {
+		offset := size - window
+		if offset <= 0 {
+			return 0
+		}
+
+		chunk, _, modified, err := p.readLogRange(ctx, taskID, filePath, offset)
+		if err != nil || !modified {
+			return 0
+		}
+		if len(parseDockerLogLines(chunk)) >= tail {
+			return offset
+		}
+	}
+}
+
+// fileSize returns the current size, in bytes, of a task's file, used to seek near EOF when
+// honouring Tail instead of reading the whole file from the start.
+func (p *Provider) fileSize(ctx context.Context, taskID, filePath string) (int64, error) {
+	resp, err := p.fileClient.GetPropertiesFromTask(ctx, p.batchConfig.JobID, taskID, filePath, nil, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Response == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+}
+
+// parseDockerLogLines parses docker's JSON log format line-by-line, skipping any line that
+// doesn't parse so a partially-written final line while following doesn't abort the stream.
+func parseDockerLogLines(raw []byte) []dockerLogLine {
+	var lines []dockerLogLine
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		var line dockerLogLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// tailLines keeps only the last n entries of lines.
+func tailLines(lines []dockerLogLine, n int) []dockerLogLine {
+	if n <= 0 || len(lines) <= n {
+		return lines
+	}
+	return lines[len(lines)-n:]
+}
+
+// sinceTime resolves opts.SinceTime/SinceSeconds to a single cutoff, preferring SinceTime.
+func sinceTime(opts LogOptions) time.Time {
+	if !opts.SinceTime.IsZero() {
+		return opts.SinceTime
+	}
+	if opts.SinceSeconds > 0 {
+		return time.Now().Add(-time.Duration(opts.SinceSeconds) * time.Second)
+	}
+	return time.Time{}
+}
+
+// filterSince drops any line logged before since. A zero since disables filtering.
+func filterSince(lines []dockerLogLine, since time.Time) []dockerLogLine {
+	if since.IsZero() {
+		return lines
+	}
+	filtered := lines[:0]
+	for _, line := range lines {
+		if !line.Time.Before(since) {
+			filtered = append(filtered, line)
+		}
+	}
+	return filtered
+}
+
+// formatLogLine re-emits a parsed docker log line as plain text, optionally prefixed with
+// its timestamp, matching `kubectl logs --timestamps` output.
+func formatLogLine(line dockerLogLine, timestamps bool) []byte {
+	if !timestamps {
+		return []byte(line.Log)
+	}
+	return []byte(line.Time.Format(time.RFC3339Nano) + " " + line.Log)
+}
+
+// isRangeNotSatisfiable reports whether err corresponds to an HTTP 416 response from the
+// Batch file API, which it returns when offset is already at EOF.
+func isRangeNotSatisfiable(err error) bool {
+	detailed, ok := err.(autorest.DetailedError)
+	if !ok || detailed.Response == nil {
+		return false
+	}
+	return detailed.Response.StatusCode == http.StatusRequestedRangeNotSatisfiable
+}
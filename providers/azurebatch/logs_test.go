@@ -0,0 +1,123 @@
+package azurebatch
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestParseDockerLogLines(t *testing.T) {
+	raw := []byte(
+		"{\"log\":\"hello\\n\",\"stream\":\"stdout\",\"time\":\"2018-01-01T00:00:00Z\"}\n" +
+			"not json\n" +
+			"{\"log\":\"world\\n\",\"stream\":\"stderr\",\"time\":\"2018-01-01T00:00:01Z\"}\n",
+	)
+
+	lines := parseDockerLogLines(raw)
+	if len(lines) != 2 {
+		t.Fatalf("parseDockerLogLines() returned %d lines, want 2 (malformed line should be skipped)", len(lines))
+	}
+	if lines[0].Log != "hello\n" || lines[0].Stream != "stdout" {
+		t.Errorf("lines[0] = %+v, want log=hello stream=stdout", lines[0])
+	}
+	if lines[1].Log != "world\n" || lines[1].Stream != "stderr" {
+		t.Errorf("lines[1] = %+v, want log=world stream=stderr", lines[1])
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	lines := []dockerLogLine{{Log: "a"}, {Log: "b"}, {Log: "c"}, {Log: "d"}}
+
+	cases := []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{"fewer than available", 2, []string{"c", "d"}},
+		{"more than available", 10, []string{"a", "b", "c", "d"}},
+		{"zero means no limit", 0, []string{"a", "b", "c", "d"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tailLines(lines, tc.n)
+			if len(got) != len(tc.want) {
+				t.Fatalf("tailLines(%d) returned %d lines, want %d", tc.n, len(got), len(tc.want))
+			}
+			for i, line := range got {
+				if line.Log != tc.want[i] {
+					t.Errorf("tailLines(%d)[%d] = %q, want %q", tc.n, i, line.Log, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFilterSince(t *testing.T) {
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	lines := []dockerLogLine{
+		{Log: "old", Time: base},
+		{Log: "new", Time: base.Add(time.Minute)},
+	}
+
+	t.Run("zero since disables filtering", func(t *testing.T) {
+		got := filterSince(lines, time.Time{})
+		if len(got) != 2 {
+			t.Fatalf("filterSince() returned %d lines, want 2", len(got))
+		}
+	})
+
+	t.Run("drops lines before cutoff", func(t *testing.T) {
+		got := filterSince(lines, base.Add(30*time.Second))
+		if len(got) != 1 || got[0].Log != "new" {
+			t.Fatalf("filterSince() = %+v, want only the \"new\" line", got)
+		}
+	})
+}
+
+func TestIsRangeNotSatisfiable(t *testing.T) {
+	t.Run("416 DetailedError", func(t *testing.T) {
+		err := autorest.DetailedError{
+			Response: &http.Response{StatusCode: http.StatusRequestedRangeNotSatisfiable},
+		}
+		if !isRangeNotSatisfiable(err) {
+			t.Error("isRangeNotSatisfiable() = false, want true for a 416 response")
+		}
+	})
+
+	t.Run("other DetailedError status", func(t *testing.T) {
+		err := autorest.DetailedError{
+			Response: &http.Response{StatusCode: http.StatusInternalServerError},
+		}
+		if isRangeNotSatisfiable(err) {
+			t.Error("isRangeNotSatisfiable() = true, want false for a 500 response")
+		}
+	})
+
+	t.Run("unrelated error, even one mentioning 416", func(t *testing.T) {
+		if isRangeNotSatisfiable(errors.New("timeout after 416ms")) {
+			t.Error("isRangeNotSatisfiable() = true, want false for a non-DetailedError")
+		}
+	})
+}
+
+func TestSinceTime(t *testing.T) {
+	explicit := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("explicit SinceTime wins", func(t *testing.T) {
+		got := sinceTime(LogOptions{SinceTime: explicit, SinceSeconds: 60})
+		if !got.Equal(explicit) {
+			t.Errorf("sinceTime() = %v, want %v", got, explicit)
+		}
+	})
+
+	t.Run("no bound set", func(t *testing.T) {
+		got := sinceTime(LogOptions{})
+		if !got.IsZero() {
+			t.Errorf("sinceTime() = %v, want zero time", got)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package azurebatch
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/batch/2017-09-01.6.0/batch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// statusSyncInterval is how often the statusManager pages through Batch tasks looking for
+// status changes. Kept short relative to typical task runtimes so GetPodStatus callers see
+// fresh data without hammering the Batch API on every request.
+const statusSyncInterval = 10 * time.Second
+
+// podStatusEntry is a cached pod status together with the StartTime we first observed for
+// it, so a later sync never regresses StartTime even if Batch reports the task as freshly
+// running again after a worker node restart.
+type podStatusEntry struct {
+	task      batch.CloudTask
+	startTime *metav1.Time
+}
+
+// statusManager mirrors kubelet's statusManager: it owns the authoritative, locally cached
+// view of task status and keeps it in sync with Batch in the background so read paths
+// (GetPod, GetPodStatus, GetPods) never block on a Batch API round trip.
+type statusManager struct {
+	mu    sync.RWMutex
+	cache map[string]*podStatusEntry // keyed by namespace/name
+
+	listTasks func() (*[]batch.CloudTask, error)
+
+	// syncRequests lets callers (e.g. a just-created pod) ask for an immediate resync
+	// instead of waiting for the next tick.
+	syncRequests chan struct{}
+}
+
+// newStatusManager creates a statusManager backed by the provider's listTasks func. Call
+// Run to start the background reconciliation loop.
+func newStatusManager(listTasks func() (*[]batch.CloudTask, error)) *statusManager {
+	return &statusManager{
+		cache:        make(map[string]*podStatusEntry),
+		listTasks:    listTasks,
+		syncRequests: make(chan struct{}, 1),
+	}
+}
+
+// Run starts the background reconciliation loop and blocks until ctx is cancelled. It syncs
+// once immediately so the cache is warm before the first GetPodStatus call, then resyncs on
+// statusSyncInterval or whenever requestSync is called.
+func (s *statusManager) Run(ctx context.Context) {
+	s.syncBatch()
+
+	ticker := time.NewTicker(statusSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.syncBatch()
+		case <-s.syncRequests:
+			s.syncBatch()
+		}
+	}
+}
+
+// requestSync asks the reconciliation loop to run a sync as soon as possible, without
+// waiting for statusSyncInterval to elapse. Non-blocking: if a sync is already pending the
+// request is dropped.
+func (s *statusManager) requestSync() {
+	select {
+	case s.syncRequests <- struct{}{}:
+	default:
+	}
+}
+
+// syncBatch pages through every task in the job via listTasks, diffs it against the cache
+// and replaces each entry, preserving StartTime across updates so a task that briefly
+// reports as re-queued doesn't appear to restart from the pod's point of view.
+func (s *statusManager) syncBatch() {
+	tasksPtr, err := s.listTasks()
+	if err != nil {
+		log.Printf("azurebatch: statusManager sync failed: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool, len(*tasksPtr))
+	for _, task := range *tasksPtr {
+		if task.ID == nil {
+			continue
+		}
+		key := *task.ID
+		seen[key] = true
+
+		existing, ok := s.cache[key]
+		startTime := firstNonNilTime(task.ExecutionInfo)
+		if ok && existing.startTime != nil {
+			startTime = existing.startTime
+		}
+
+		s.cache[key] = &podStatusEntry{
+			task:      task,
+			startTime: startTime,
+		}
+	}
+
+	// Drop cache entries for tasks that no longer exist in Batch (e.g. deleted out of
+	// band), mirroring how kubelet's statusManager reconciles against the source of truth.
+	for key := range s.cache {
+		if !seen[key] {
+			delete(s.cache, key)
+		}
+	}
+}
+
+// get returns the cached task and the StartTime we've pinned for it, if any.
+func (s *statusManager) get(taskID string) (batch.CloudTask, *metav1.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.cache[taskID]
+	if !ok {
+		return batch.CloudTask{}, nil, false
+	}
+	return entry.task, entry.startTime, true
+}
+
+// forget synchronously evicts taskID from the cache. DeletePod calls this before returning
+// so a same-request CreatePod (as UpdatePod does) sees the deletion immediately - waiting
+// for requestSync's background resync to observe it would mean checkDuplicate still sees
+// the task as present microseconds later, rejecting the recreate as a duplicate.
+func (s *statusManager) forget(taskID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, taskID)
+}
+
+// list returns every cached entry, each still carrying its pinned StartTime - callers must
+// apply it the same way get's caller does, or a synced StartTime is silently dropped.
+func (s *statusManager) list() []podStatusEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]podStatusEntry, 0, len(s.cache))
+	for _, entry := range s.cache {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// firstNonNilTime pulls a StartTime out of a task's ExecutionInfo, if Batch has reported one.
+func firstNonNilTime(info *batch.TaskExecutionInformation) *metav1.Time {
+	if info == nil || info.StartTime == nil {
+		return nil
+	}
+	t := metav1.NewTime(info.StartTime.ToTime())
+	return &t
+}
@@ -0,0 +1,265 @@
+package azurebatch
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/batch/2017-09-01.6.0/batch"
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/go-autorest/autorest/to"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// azureFilesMountPoint is the path the pool's start task mounts the configured
+	// Azure Files share to on every node, once per node.
+	azureFilesMountPoint = "/mnt/batch/azurefiles"
+	// volumeResourceBasePath is where per-task volume content (ConfigMaps/Secrets) is
+	// written before the task's command line runs, relative to the task working directory.
+	volumeResourceBasePath = "volumes"
+	// volumeContentContainer is the blob container ConfigMap/Secret volume content is
+	// staged in so the node agent can fetch it as a ResourceFile's BlobSource - the node
+	// agent resolves BlobSource with an HTTP(S) GET, so the content has to live somewhere
+	// fetchable rather than be embedded directly in the task definition.
+	volumeContentContainer = "vk-volumes"
+	// volumeContentSASTTL bounds how long the SAS URL we hand to a task stays valid - long
+	// enough to cover task start, short enough to limit exposure of Secret content.
+	volumeContentSASTTL = 1 * time.Hour
+	// fileShareKeyEnvVar is the name of the StartTask environment variable the mount command
+	// reads the storage account key from, so it never appears in the command line itself -
+	// CommandLine is readable by anyone with read access to the pool (poolClient.Get/List).
+	fileShareKeyEnvVar = "AZUREBATCH_FILESHARE_KEY"
+)
+
+// resolveVolumes inspects a pod's Spec.Volumes and returns the ResourceFiles that must be
+// attached to the Batch task so the content referenced by ConfigMap and Secret volumes is
+// present in the task's working directory before the container command executes. Volume
+// types backed by the pool-wide Azure Files share (PersistentVolumeClaim, AzureFile) are
+// not returned here as they're already available at azureFilesMountPoint on every node.
+func (p *Provider) resolveVolumes(pod *v1.Pod) ([]batch.ResourceFile, error) {
+	var resourceFiles []batch.ResourceFile
+
+	for _, vol := range pod.Spec.Volumes {
+		switch {
+		case vol.ConfigMap != nil:
+			files, err := p.configMapResourceFiles(pod.Namespace, pod.Name, vol.Name, vol.ConfigMap)
+			if err != nil {
+				return nil, fmt.Errorf("resolving configmap volume %q for pod %s/%s: %v", vol.Name, pod.Namespace, pod.Name, err)
+			}
+			resourceFiles = append(resourceFiles, files...)
+		case vol.Secret != nil:
+			files, err := p.secretResourceFiles(pod.Namespace, pod.Name, vol.Name, vol.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("resolving secret volume %q for pod %s/%s: %v", vol.Name, pod.Namespace, pod.Name, err)
+			}
+			resourceFiles = append(resourceFiles, files...)
+		case vol.EmptyDir != nil:
+			// EmptyDir needs no provisioning - the task working directory already
+			// provides scratch space local to the node.
+			continue
+		case vol.AzureFile != nil, vol.PersistentVolumeClaim != nil:
+			// Backed by the pool-wide share mounted by the start task in
+			// ensureFilesShareMounted; nothing to add per-task.
+			continue
+		default:
+			log.Printf("azurebatch: volume %q on pod %s/%s has an unsupported type, skipping", vol.Name, pod.Namespace, pod.Name)
+		}
+	}
+
+	return resourceFiles, nil
+}
+
+// volumeFilePath is where a volume's key ends up relative to the task working directory.
+func volumeFilePath(volumeName, key string) string {
+	return fmt.Sprintf("%s/%s/%s", volumeResourceBasePath, volumeName, key)
+}
+
+// podVolumeBlobPrefix is the blob-name prefix every piece of volume content staged for a
+// pod is written under, so cleanupVolumes can delete all of it with a single prefix listing
+// regardless of which ConfigMaps/Secrets the pod referenced or how many revisions of them
+// were staged across updates.
+func podVolumeBlobPrefix(namespace, podName string) string {
+	return fmt.Sprintf("%s/%s", namespace, podName)
+}
+
+// volumeContentBlobName is the blob a given volume key's content is staged under. Including
+// resourceVersion means an update that changes a ConfigMap/Secret stages a new blob rather
+// than racing an in-place overwrite with a task that's still reading the old one.
+func volumeContentBlobName(namespace, podName, volumeName, key, resourceVersion string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", podVolumeBlobPrefix(namespace, podName), volumeName, key, resourceVersion)
+}
+
+// configMapResourceFiles materializes a ConfigMap's keys as files under volumes/<name>/<key>
+// in the task working directory, staging each key's content as a blob and pointing the
+// ResourceFile at a short-lived read-only SAS URL for it.
+func (p *Provider) configMapResourceFiles(namespace, podName, volumeName string, source *v1.ConfigMapVolumeSource) ([]batch.ResourceFile, error) {
+	configMap, err := p.resourceManager.GetConfigMap(source.Name, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []batch.ResourceFile
+	for key, value := range configMap.Data {
+		blobName := volumeContentBlobName(namespace, podName, volumeName, key, configMap.ResourceVersion)
+		sasURL, err := p.stageVolumeContent(blobName, []byte(value))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, batch.ResourceFile{
+			FilePath:   to.StringPtr(volumeFilePath(volumeName, key)),
+			FileMode:   to.StringPtr("0644"),
+			BlobSource: to.StringPtr(sasURL),
+		})
+	}
+	return files, nil
+}
+
+// secretResourceFiles materializes a Secret's keys as files under volumes/<name>/<key> in
+// the task working directory, staging each key's content as a blob and pointing the
+// ResourceFile at a short-lived read-only SAS URL for it - never embedding the value
+// directly in the task definition, where anyone able to call taskClient.Get could read it.
+func (p *Provider) secretResourceFiles(namespace, podName, volumeName string, source *v1.SecretVolumeSource) ([]batch.ResourceFile, error) {
+	secret, err := p.resourceManager.GetSecret(source.SecretName, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []batch.ResourceFile
+	for key, value := range secret.Data {
+		blobName := volumeContentBlobName(namespace, podName, volumeName, key, secret.ResourceVersion)
+		sasURL, err := p.stageVolumeContent(blobName, value)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, batch.ResourceFile{
+			FilePath:   to.StringPtr(volumeFilePath(volumeName, key)),
+			FileMode:   to.StringPtr("0600"),
+			BlobSource: to.StringPtr(sasURL),
+		})
+	}
+	return files, nil
+}
+
+// volumeBlobContainer returns a blob container client for the configured storage account.
+func (p *Provider) volumeBlobContainer() (*storage.Container, error) {
+	if p.batchConfig.StorageAccountName == "" || p.batchConfig.StorageAccountKey == "" {
+		return nil, fmt.Errorf("no storage account configured to stage volume content")
+	}
+
+	client, err := storage.NewBasicClient(p.batchConfig.StorageAccountName, p.batchConfig.StorageAccountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetBlobService().GetContainerReference(volumeContentContainer), nil
+}
+
+// stageVolumeContent uploads content to volumeContentContainer under blobName and returns a
+// read-only SAS URL the Batch node agent can GET it from as a ResourceFile BlobSource.
+func (p *Provider) stageVolumeContent(blobName string, content []byte) (string, error) {
+	container, err := p.volumeBlobContainer()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := container.CreateIfNotExists(nil); err != nil {
+		return "", err
+	}
+
+	blobRef := container.GetBlobReference(blobName)
+	if err := blobRef.CreateBlockBlobFromReader(bytes.NewReader(content), nil); err != nil {
+		return "", err
+	}
+
+	return blobRef.GetSASURI(storage.BlobSASOptions{
+		BlobServiceSASPermissions: storage.BlobServiceSASPermissions{Read: true},
+		SASOptions: storage.SASOptions{
+			Expiry: time.Now().Add(volumeContentSASTTL),
+		},
+	})
+}
+
+// ensureFilesShareMounted patches the pool's start task so it installs the SMB/CIFS client
+// and mounts the configured Azure Files share to azureFilesMountPoint on every node. This
+// must be an explicit Patch call rather than a mutation of the in-memory *batch.Pool -
+// createOrGetPool's "Get" branch (the common case after the first run) returns a pool
+// already known to the service, and nothing else in NewBatchProvider pushes local changes
+// back to it.
+func (p *Provider) ensureFilesShareMounted() error {
+	if p.batchConfig.StorageAccountName == "" || p.batchConfig.FileShareName == "" {
+		log.Println("azurebatch: no storage account/file share configured, skipping volume mount start task")
+		return nil
+	}
+
+	mountCommand := fmt.Sprintf(
+		`/bin/bash -c "apt-get update && apt-get install -y cifs-utils && mkdir -p %s && mount -t cifs //%s.file.core.windows.net/%s %s -o vers=3.0,username=%s,password=$%s,dir_mode=0777,file_mode=0777,serverino"`,
+		azureFilesMountPoint,
+		p.batchConfig.StorageAccountName,
+		p.batchConfig.FileShareName,
+		azureFilesMountPoint,
+		p.batchConfig.StorageAccountName,
+		fileShareKeyEnvVar,
+	)
+
+	startTask := &batch.StartTask{
+		CommandLine: to.StringPtr(mountCommand),
+		EnvironmentSettings: &[]batch.EnvironmentSetting{
+			{
+				Name:  to.StringPtr(fileShareKeyEnvVar),
+				Value: to.StringPtr(p.batchConfig.StorageAccountKey),
+			},
+		},
+		UserIdentity: &batch.UserIdentity{
+			AutoUser: &batch.AutoUserSpecification{
+				ElevationLevel: batch.Admin,
+				Scope:          batch.Pool,
+			},
+		},
+		WaitForSuccess: to.BoolPtr(true),
+	}
+
+	_, err := p.poolClient.Patch(p.ctx, p.batchConfig.PoolID, batch.PoolPatchParameter{
+		StartTask: startTask,
+	}, nil, nil, nil, nil, "", "", nil, nil)
+	return err
+}
+
+// cleanupVolumes deletes every blob staged for this pod's ConfigMap/Secret volumes - across
+// every revision CreatePod/UpdatePod ever wrote - so DeletePod doesn't leak storage. The
+// task working directory itself needs no cleanup here: Batch reclaims that when the task is
+// deleted, but the blobs staged in volumeContentContainer are a separate resource in the
+// storage account that nothing else ever removes.
+func (p *Provider) cleanupVolumes(pod *v1.Pod) error {
+	hasStagedVolume := false
+	for _, vol := range pod.Spec.Volumes {
+		if vol.ConfigMap != nil || vol.Secret != nil {
+			hasStagedVolume = true
+			break
+		}
+	}
+	if !hasStagedVolume {
+		return nil
+	}
+
+	container, err := p.volumeBlobContainer()
+	if err != nil {
+		return err
+	}
+
+	// The trailing slash keeps "ns/pod" from also matching another pod's "ns/pod-2/..." blobs.
+	prefix := podVolumeBlobPrefix(pod.Namespace, pod.Name) + "/"
+	resp, err := container.ListBlobs(storage.ListBlobsParameters{Prefix: prefix})
+	if err != nil {
+		return fmt.Errorf("listing staged volume blobs for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+
+	for _, b := range resp.Blobs {
+		blob := b
+		if _, err := container.GetBlobReference(blob.Name).DeleteIfExists(nil); err != nil {
+			return fmt.Errorf("deleting staged volume blob %q for pod %s/%s: %v", blob.Name, pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package azurebatch
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestVolumeFilePath(t *testing.T) {
+	got := volumeFilePath("config", "app.conf")
+	want := "volumes/config/app.conf"
+	if got != want {
+		t.Errorf("volumeFilePath() = %q, want %q", got, want)
+	}
+}
+
+func TestPodVolumeBlobPrefix(t *testing.T) {
+	got := podVolumeBlobPrefix("default", "my-pod")
+	want := "default/my-pod"
+	if got != want {
+		t.Errorf("podVolumeBlobPrefix() = %q, want %q", got, want)
+	}
+}
+
+func TestVolumeContentBlobName(t *testing.T) {
+	got := volumeContentBlobName("default", "my-pod", "config", "app.conf", "123")
+	want := "default/my-pod/config/app.conf/123"
+	if got != want {
+		t.Errorf("volumeContentBlobName() = %q, want %q", got, want)
+	}
+
+	t.Run("is prefixed by podVolumeBlobPrefix", func(t *testing.T) {
+		prefix := podVolumeBlobPrefix("default", "my-pod")
+		if got[:len(prefix)] != prefix {
+			t.Errorf("volumeContentBlobName() = %q, want it prefixed with %q so cleanupVolumes can list it", got, prefix)
+		}
+	})
+}
+
+func TestResolveVolumesPassThroughTypes(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+				{Name: "share", VolumeSource: v1.VolumeSource{AzureFile: &v1.AzureFileVolumeSource{ShareName: "s"}}},
+				{Name: "claim", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "c"}}},
+				{Name: "unsupported", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/tmp"}}},
+			},
+		},
+	}
+
+	p := &Provider{}
+	files, err := p.resolveVolumes(pod)
+	if err != nil {
+		t.Fatalf("resolveVolumes() error = %v, want nil", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("resolveVolumes() = %d files, want 0 - none of these volume types need a ResourceFile", len(files))
+	}
+}